@@ -0,0 +1,75 @@
+package azure
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	azureOperationStatusURL = "operations/%s"
+
+	operationStatusSucceeded = "Succeeded"
+	operationStatusFailed = "Failed"
+
+	defaultAsyncPollInterval = 10 * time.Second
+)
+
+// Operation is the status document Azure returns for an async request ID.
+type Operation struct {
+	XMLName xml.Name `xml:"Operation"`
+	ID string
+	Status string
+	Error *OperationError `xml:",omitempty"`
+}
+
+// OperationError carries the failure reason for a Failed Operation.
+type OperationError struct {
+	Code string
+	Message string
+}
+
+// WaitAsyncOperationWithContext polls the status of requestId every
+// pollInterval (a non-positive pollInterval falls back to a 10 second
+// default) until it reaches a terminal state, returning an error built from
+// the Operation's Error on failure. ctx is checked while waiting between
+// polls: once it is cancelled or times out, ctx.Err() is returned without
+// waiting for the in-flight operation to reach a terminal state. A status
+// request already under way is not interrupted.
+func WaitAsyncOperationWithContext(ctx context.Context, requestId string, pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = defaultAsyncPollInterval
+	}
+
+	requestURL := fmt.Sprintf(azureOperationStatusURL, requestId)
+
+	for {
+		response, err := SendAzureGetRequest(requestURL)
+		if err != nil {
+			return err
+		}
+
+		operation := new(Operation)
+		if err := xml.Unmarshal(response, operation); err != nil {
+			return err
+		}
+
+		switch operation.Status {
+		case operationStatusSucceeded:
+			return nil
+		case operationStatusFailed:
+			if operation.Error != nil {
+				return errors.New(operation.Error.Message)
+			}
+			return errors.New(fmt.Sprintf("operation %s failed", requestId))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
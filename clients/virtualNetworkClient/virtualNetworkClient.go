@@ -0,0 +1,147 @@
+package virtualNetworkClient
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/locationClient"
+	azure "github.com/MSOpenTech/azure-sdk-for-go"
+)
+
+const (
+	azureXmlns = "http://schemas.microsoft.com/windowsazure"
+	azureNetworkConfigurationURL = "services/networking/media"
+
+	invalidNetworkConfigError = "Virtual network %s not found"
+)
+
+type NetworkConfiguration struct {
+	XMLName xml.Name `xml:"NetworkConfiguration"`
+	Xmlns string `xml:"xmlns,attr"`
+	VirtualNetworkConfiguration VirtualNetworkConfiguration
+}
+
+type VirtualNetworkConfiguration struct {
+	VirtualNetworkSites VirtualNetworkSites
+}
+
+type VirtualNetworkSites struct {
+	VirtualNetworkSite []VirtualNetworkSite
+}
+
+type VirtualNetworkSite struct {
+	Name string `xml:"name,attr"`
+	Location string `xml:"Location,attr"`
+	AddressSpace AddressSpace
+	Subnets Subnets
+}
+
+type AddressSpace struct {
+	AddressPrefix []string
+}
+
+type Subnets struct {
+	Subnet []Subnet
+}
+
+type Subnet struct {
+	Name string
+	AddressPrefix string
+}
+
+// ListVirtualNetworks returns every virtual network site configured for the
+// subscription.
+func ListVirtualNetworks() ([]VirtualNetworkSite, error) {
+	networkConfig, err := GetVirtualNetworkConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	return networkConfig.VirtualNetworkConfiguration.VirtualNetworkSites.VirtualNetworkSite, nil
+}
+
+// GetVirtualNetworkConfiguration fetches the subscription's network
+// configuration document in full.
+func GetVirtualNetworkConfiguration() (*NetworkConfiguration, error) {
+	networkConfig := new(NetworkConfiguration)
+
+	response, err := azure.SendAzureGetRequest(azureNetworkConfigurationURL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = xml.Unmarshal(response, networkConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return networkConfig, nil
+}
+
+// CreateVirtualNetwork adds a virtual network site named name, in location,
+// covering addressPrefix (e.g. "10.0.0.0/16"), with the given subnets
+// (subnet name -> address prefix). The full network configuration document
+// is replaced, so this call is not safe to run concurrently with other
+// network configuration changes.
+func CreateVirtualNetwork(name, location, addressPrefix string, subnets map[string]string) error {
+	err := locationClient.ResolveLocation(location)
+	if err != nil {
+		return err
+	}
+
+	networkConfig, err := GetVirtualNetworkConfiguration()
+	if err != nil {
+		return err
+	}
+
+	site := VirtualNetworkSite{}
+	site.Name = name
+	site.Location = location
+	site.AddressSpace.AddressPrefix = append(site.AddressSpace.AddressPrefix, addressPrefix)
+
+	for subnetName, subnetPrefix := range subnets {
+		subnet := Subnet{}
+		subnet.Name = subnetName
+		subnet.AddressPrefix = subnetPrefix
+		site.Subnets.Subnet = append(site.Subnets.Subnet, subnet)
+	}
+
+	sites := &networkConfig.VirtualNetworkConfiguration.VirtualNetworkSites.VirtualNetworkSite
+	*sites = append(*sites, site)
+
+	return putVirtualNetworkConfiguration(networkConfig)
+}
+
+// GetSubnets returns the subnets configured on the named virtual network.
+func GetSubnets(virtualNetworkName string) ([]Subnet, error) {
+	sites, err := ListVirtualNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, site := range sites {
+		if site.Name == virtualNetworkName {
+			return site.Subnets.Subnet, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf(invalidNetworkConfigError, virtualNetworkName))
+}
+
+func putVirtualNetworkConfiguration(networkConfig *NetworkConfiguration) error {
+	networkConfig.Xmlns = azureXmlns
+
+	networkConfigBytes, err := xml.Marshal(networkConfig)
+	if err != nil {
+		return err
+	}
+
+	requestId, err := azure.SendAzurePutRequest(azureNetworkConfigurationURL, "netconfig", networkConfigBytes)
+	if err != nil {
+		return err
+	}
+
+	azure.WaitAsyncOperation(requestId)
+	return nil
+}
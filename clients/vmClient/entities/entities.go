@@ -0,0 +1,199 @@
+// Package entities holds the Role configuration types shared by vmClient
+// and vmutils. They live in their own package (rather than in vmClient,
+// where they originated) so that vmutils can build up a *Role without
+// importing vmClient: vmClient imports vmutils to implement its
+// high-level provisioning helpers on top of it, so vmutils importing
+// vmClient back would be a cycle.
+package entities
+
+// Role is an Azure persistent VM role: the deployable unit combining an OS
+// disk, zero or more data disks, provisioning/network configuration sets
+// and resource extensions.
+type Role struct {
+	RoleName string
+	RoleType string
+	ConfigurationSets ConfigurationSets
+	ResourceExtensionReferences ResourceExtensionReferences
+	AvailabilitySetName string `xml:",omitempty"`
+	OSVirtualHardDisk OSVirtualHardDisk
+	RoleSize string
+	ProvisionGuestAgent bool
+
+	// UseCertAuth and CertPath are not part of Azure's wire schema; they
+	// record whether CreateAzureVM needs to upload a service certificate
+	// before deploying this role.
+	UseCertAuth bool `xml:"-"`
+	CertPath string `xml:"-"`
+}
+
+// ConfigurationSets is the XML wrapper Azure expects around a role's
+// individual configuration sets.
+type ConfigurationSets struct {
+	ConfigurationSet []ConfigurationSet
+}
+
+// ConfigurationSet is either a LinuxProvisioningConfiguration, a
+// WindowsProvisioningConfiguration or a NetworkConfiguration, distinguished
+// by ConfigurationSetType. Fields that don't apply to a given type are left
+// at their zero value.
+type ConfigurationSet struct {
+	ConfigurationSetType string
+
+	// LinuxProvisioningConfiguration fields.
+	DisableSshPasswordAuthentication bool
+	HostName string `xml:",omitempty"`
+	UserName string `xml:",omitempty"`
+	UserPassword string `xml:",omitempty"`
+	SSH SSH `xml:",omitempty"`
+
+	// WindowsProvisioningConfiguration fields.
+	ComputerName string `xml:",omitempty"`
+	AdminUsername string `xml:",omitempty"`
+	AdminPassword string `xml:",omitempty"`
+	EnableAutomaticUpdates bool `xml:",omitempty"`
+	TimeZone string `xml:",omitempty"`
+	WinRMListeners *WinRMListeners `xml:",omitempty"`
+
+	// NetworkConfiguration fields.
+	InputEndpoints InputEndpoints `xml:",omitempty"`
+	SubnetNames []string `xml:",omitempty"`
+	StaticVirtualNetworkIPAddress string `xml:",omitempty"`
+}
+
+// SSH is the XML wrapper Azure expects around a Linux role's public keys.
+type SSH struct {
+	PublicKeys PublicKeys
+}
+
+// PublicKeys is the XML wrapper Azure expects around the individual
+// PublicKey entries.
+type PublicKeys struct {
+	PublicKey []PublicKey
+}
+
+// PublicKey identifies an SSH public key by the fingerprint of the service
+// certificate it was uploaded as, and the path it should be installed at.
+type PublicKey struct {
+	Fingerprint string
+	Path string
+}
+
+// WinRMListeners is the XML wrapper Azure expects around the individual
+// WinRM listener entries.
+type WinRMListeners struct {
+	Listener []WinRMListener
+}
+
+// WinRMListener describes a single WinRM protocol listener (HTTP or HTTPS)
+// to be enabled on a Windows role.
+type WinRMListener struct {
+	Protocol string
+	CertificateThumbprint string `xml:",omitempty"`
+}
+
+// InputEndpoints is the XML wrapper Azure expects around a network
+// configuration set's individual endpoint entries.
+type InputEndpoints struct {
+	InputEndpoint []InputEndpoint
+}
+
+// InputEndpoint is a single port mapping exposed on a role, optionally
+// joined to a load-balanced set, health-probed and access-controlled.
+type InputEndpoint struct {
+	Name string
+	Protocol string
+	Port int
+	LocalPort int
+	LoadBalancedEndpointSetName string `xml:",omitempty"`
+	IdleTimeoutInMinutes int `xml:",omitempty"`
+	LoadBalancerProbe *LoadBalancerProbe `xml:",omitempty"`
+	EndpointACL *EndpointACL `xml:",omitempty"`
+}
+
+// LoadBalancerProbe configures the health probe Azure uses to decide
+// whether an instance in a LoadBalancedEndpointSetName is healthy.
+type LoadBalancerProbe struct {
+	Path string `xml:",omitempty"`
+	Port int
+	Protocol string
+	IntervalInSeconds int `xml:",omitempty"`
+	TimeoutInSeconds int `xml:",omitempty"`
+}
+
+// EndpointACL is the XML wrapper Azure expects around an endpoint's ACL
+// rules.
+type EndpointACL struct {
+	Rules ACLRules
+}
+
+// ACLRules is the XML wrapper Azure expects around the individual ACLRule
+// entries.
+type ACLRules struct {
+	Rule []ACLRule
+}
+
+// ACLRule is a single ordered permit/deny rule in an endpoint's access
+// control list.
+type ACLRule struct {
+	Order int
+	Action string
+	RemoteSubnet string
+	Description string `xml:",omitempty"`
+}
+
+// OSVirtualHardDisk describes a role's OS disk: the published image it was
+// created from, the blob it was materialized to, and any data disks
+// attached alongside it.
+type OSVirtualHardDisk struct {
+	SourceImageName string
+	MediaLink string
+	ResizedSizeInGB int `xml:",omitempty"`
+	DataVirtualHardDisks DataVirtualHardDisks `xml:",omitempty"`
+}
+
+// DataVirtualHardDisks is the XML wrapper Azure expects around the
+// individual data disk entries of an OSVirtualHardDisk.
+type DataVirtualHardDisks struct {
+	DataVirtualHardDisk []DataVirtualHardDisk
+}
+
+// DataVirtualHardDisk describes a data disk attached to a role, either
+// newly created or pointing at an existing VHD blob.
+type DataVirtualHardDisk struct {
+	HostCaching string `xml:",omitempty"`
+	DiskName string `xml:",omitempty"`
+	Lun int
+	LogicalDiskSizeInGB int `xml:",omitempty"`
+	MediaLink string
+}
+
+// ResourceExtensionReferences is the XML wrapper Azure expects around a
+// role's individual resource extension entries.
+type ResourceExtensionReferences struct {
+	ResourceExtensionReference []ResourceExtensionReference
+}
+
+// ResourceExtensionReference attaches a published VM extension (e.g.
+// CustomScriptForLinux, the Docker extension) to a role.
+type ResourceExtensionReference struct {
+	Name string
+	Publisher string
+	Version string
+	ReferenceName string
+	State string
+	ResourceExtensionParameterValues ResourceExtensionParameterValues `xml:",omitempty"`
+}
+
+// ResourceExtensionParameterValues is the XML wrapper Azure expects around
+// a resource extension's individual public/private configuration values.
+type ResourceExtensionParameterValues struct {
+	ResourceExtensionParameterValue []ResourceExtensionParameter
+}
+
+// ResourceExtensionParameter carries a single base64-encoded public or
+// private configuration document for a resource extension.
+type ResourceExtensionParameter struct {
+	Key string
+	Value string
+	Type string
+}
@@ -1,9 +1,11 @@
 package vmClient
 
 import (
+	"context"
 	"fmt"
 	"time"
 	"encoding/xml"
+	"encoding/json"
 	"encoding/base64"
 	"encoding/pem"
 	"os"
@@ -18,9 +20,41 @@ import (
 	"github.com/MSOpenTech/azure-sdk-for-go/clients/locationClient"
 	"github.com/MSOpenTech/azure-sdk-for-go/clients/imageClient"
 	"github.com/MSOpenTech/azure-sdk-for-go/clients/storageServiceClient"
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/vmClient/entities"
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/vmClient/vmutils"
 	azure "github.com/MSOpenTech/azure-sdk-for-go"
 )
 
+// Role, ConfigurationSet and the other types below alias the shared
+// definitions in the entities package so that existing callers of vmClient
+// keep compiling unchanged now that vmutils builds *Role values directly
+// on entities (see entities' package doc for why: vmClient imports
+// vmutils to implement the functions below, so vmutils importing vmClient
+// back for these types would be a cycle).
+type (
+	Role = entities.Role
+	ConfigurationSets = entities.ConfigurationSets
+	ConfigurationSet = entities.ConfigurationSet
+	SSH = entities.SSH
+	PublicKeys = entities.PublicKeys
+	PublicKey = entities.PublicKey
+	WinRMListeners = entities.WinRMListeners
+	WinRMListener = entities.WinRMListener
+	InputEndpoints = entities.InputEndpoints
+	InputEndpoint = entities.InputEndpoint
+	LoadBalancerProbe = entities.LoadBalancerProbe
+	EndpointACL = entities.EndpointACL
+	ACLRules = entities.ACLRules
+	ACLRule = entities.ACLRule
+	OSVirtualHardDisk = entities.OSVirtualHardDisk
+	DataVirtualHardDisks = entities.DataVirtualHardDisks
+	DataVirtualHardDisk = entities.DataVirtualHardDisk
+	ResourceExtensionReferences = entities.ResourceExtensionReferences
+	ResourceExtensionReference = entities.ResourceExtensionReference
+	ResourceExtensionParameterValues = entities.ResourceExtensionParameterValues
+	ResourceExtensionParameter = entities.ResourceExtensionParameter
+)
+
 const (
 	azureXmlns = "http://schemas.microsoft.com/windowsazure"
 	azureDeploymentListURL = "services/hostedservices/%s/deployments"
@@ -30,41 +64,82 @@ const (
 	azureRoleURL = "services/hostedservices/%s/deployments/%s/roles/%s"
 	azureOperationsURL = "services/hostedservices/%s/deployments/%s/roleinstances/%s/Operations"
 	azureCertificatListURL = "services/hostedservices/%s/certificates"
+	azureDataDiskURL = "services/hostedservices/%s/deployments/%s/roles/%s/DataDisks/%d"
 
-	osLinux = "Linux"
-	osWindows = "Windows"
+	defaultPollInterval = 5 * time.Second
 
 	dockerPublicConfig = "{ \"dockerport\": \"%v\" }"
 	dockerPrivateConfig = "{ \"ca\": \"%s\", \"server-cert\": \"%s\", \"server-key\": \"%s\" }"
 	dockerDirExistsMessage = "Docker directory exists"
 
+	customScriptLinuxPublisher = "Microsoft.OSTCExtensions"
+	customScriptLinuxType = "CustomScriptForLinux"
+	customScriptWindowsPublisher = "Microsoft.Compute"
+	customScriptWindowsType = "CustomScriptExtension"
+	customScriptVersion = "1.*"
+
 	missingDockerCertsError = "You should generate docker certificates first. Info can be found here: https://docs.docker.com/articles/https/"
 	provisioningConfDoesNotExistsError = "You should set azure VM provisioning config first"
+	networkConfDoesNotExistError = "You should set azure VM network config first"
+	endpointDoesNotExistError = "Endpoint %s does not exist"
 	invalidCertExtensionError = "Certificate %s is invalid. Please specify %s certificate."
-	invalidOSError = "You must specify correct OS param. Valid values are 'Linux' and 'Windows'"
 )
 
+// DeploymentOptions carries the deployment- and network-level settings that
+// CreateAzureVM can't infer from the Role alone: which virtual network and
+// subnets to join, whether to request a reserved or static IP, which
+// deployment slot to use, and which availability set to join.
+type DeploymentOptions struct {
+	VirtualNetworkName string
+	SubnetNames []string
+	ReservedIPName string
+	StaticVirtualNetworkIPAddress string
+	DeploymentSlot string
+	AvailabilitySetName string
+}
+
 // REGION PUBLIC METHODS STARTS
 
-func CreateAzureVM(role *Role, dnsName, location string) error {
+func CreateAzureVM(role *Role, dnsName, location string, options ...DeploymentOptions) error {
+	return CreateAzureVMWithContext(context.Background(), defaultPollInterval, role, dnsName, location, options...)
+}
+
+// CreateAzureVMWithContext behaves like CreateAzureVM, but polls for
+// completion of each async operation every pollInterval and returns early
+// with ctx.Err() if ctx is cancelled or times out before the deployment
+// finishes.
+func CreateAzureVMWithContext(ctx context.Context, pollInterval time.Duration, role *Role, dnsName, location string, options ...DeploymentOptions) error {
 
 	err := locationClient.ResolveLocation(location)
 	if err != nil {
 		return err
 	}
 
+	var deploymentOptions DeploymentOptions
+	if len(options) > 0 {
+		deploymentOptions = options[0]
+	}
+
+	if len(deploymentOptions.AvailabilitySetName) > 0 {
+		role.AvailabilitySetName = deploymentOptions.AvailabilitySetName
+	}
+
+	applyNetworkConfigOptions(role, deploymentOptions)
+
 	fmt.Println("Creating hosted service... ")
 	requestId, err := CreateHostedService(dnsName, location)
 	if err != nil {
 		return err
 	}
 
-	azure.WaitAsyncOperation(requestId)
+	if err := azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval); err != nil {
+		return err
+	}
 
 	if role.UseCertAuth {
 		fmt.Println("Uploading cert...")
 
-		err = uploadServiceCert(dnsName, role.CertPath)
+		err = uploadServiceCertWithContext(ctx, pollInterval, dnsName, role.CertPath)
 		if err != nil {
 			return err
 		}
@@ -72,21 +147,26 @@ func CreateAzureVM(role *Role, dnsName, location string) error {
 
 	fmt.Println("Deploying azure VM configuration... ")
 
-	vMDeployment := createVMDeploymentConfig(role)
-	vMDeploymentBytes, err := xml.Marshal(vMDeployment)
+	requestId, err = CreateAzureVMDeploymentAsync(role, deploymentOptions)
 	if err != nil {
 		return err
 	}
 
-	requestURL :=  fmt.Sprintf(azureDeploymentListURL, role.RoleName)
-	requestId, err = azure.SendAzurePostRequest(requestURL, vMDeploymentBytes)
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
+}
+
+// CreateAzureVMDeploymentAsync submits the deployment for role without
+// waiting for it to finish, returning the request ID so the caller can poll
+// it (e.g. via azure.WaitAsyncOperationWithContext) on their own schedule.
+func CreateAzureVMDeploymentAsync(role *Role, options DeploymentOptions) (string, error) {
+	vMDeployment := createVMDeploymentConfig(role, options)
+	vMDeploymentBytes, err := xml.Marshal(vMDeployment)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	azure.WaitAsyncOperation(requestId)
-
-	return nil
+	requestURL := fmt.Sprintf(azureDeploymentListURL, role.RoleName)
+	return azure.SendAzurePostRequest(requestURL, vMDeploymentBytes)
 }
 
 func CreateHostedService(dnsName, location string) (string, error) {
@@ -112,15 +192,23 @@ func CreateHostedService(dnsName, location string) (string, error) {
 }
 
 func DeleteHostedService(dnsName string) error {
+	return DeleteHostedServiceWithContext(context.Background(), defaultPollInterval, dnsName)
+}
 
-	requestURL := fmt.Sprintf(azureHostedServiceURL, dnsName)
-	requestId, err := azure.SendAzureDeleteRequest(requestURL)
+func DeleteHostedServiceWithContext(ctx context.Context, pollInterval time.Duration, dnsName string) error {
+	requestId, err := DeleteHostedServiceAsync(dnsName)
 	if err != nil {
 		return err
 	}
 
-	azure.WaitAsyncOperation(requestId)
-	return nil
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
+}
+
+// DeleteHostedServiceAsync submits the delete without waiting for it to
+// finish, returning the request ID so the caller can poll it themselves.
+func DeleteHostedServiceAsync(dnsName string) (string, error) {
+	requestURL := fmt.Sprintf(azureHostedServiceURL, dnsName)
+	return azure.SendAzureDeleteRequest(requestURL)
 }
 
 func CreateAzureVMConfiguration(name, instanceSize, imageName, location string) (*Role, error) {
@@ -139,26 +227,42 @@ func CreateAzureVMConfiguration(name, instanceSize, imageName, location string)
 	return role, nil
 }
 
+// WinRMConfig holds the WinRM listeners passed to
+// AddAzureWindowsProvisioningConfig. Only listeners whose Protocol is
+// "Https" are used; listeners with any other Protocol are ignored.
+type WinRMConfig struct {
+	Listeners []WinRMListener
+}
+
+// AddAzureLinuxProvisioningConfig adds a LinuxProvisioningConfiguration and
+// a NetworkConfiguration (exposing the standard SSH endpoint) to
+// azureVMConfig. It is a thin wrapper around vmutils.ConfigureForLinux and
+// vmutils.ConfigureWithPublicSSH, kept so existing callers don't have to
+// move to vmutils themselves; new code should call vmutils directly.
 func AddAzureLinuxProvisioningConfig(azureVMConfig *Role, userName, password, certPath string) (*Role, error) {
 	fmt.Println("Adding azure provisioning configuration... ")
 
-	configurationSets := ConfigurationSets{}
+	var fingerprints []string
+	if len(certPath) > 0 {
+		if err := checkServiceCertExtension(certPath); err != nil {
+			return nil, err
+		}
 
-	provisioningConfig, err := createLinuxProvisioningConfig(azureVMConfig.RoleName, userName, password, certPath)
-	if err != nil {
-		return nil, err
-	}
+		fingerprint, err := getServiceCertFingerprint(certPath)
+		if err != nil {
+			return nil, err
+		}
 
-	configurationSets.ConfigurationSet = append(configurationSets.ConfigurationSet, provisioningConfig)
+		fingerprints = append(fingerprints, fingerprint)
+	}
 
-	networkConfig, networkErr := createNetworkConfig(osLinux)
-	if networkErr != nil {
+	if err := vmutils.ConfigureForLinux(azureVMConfig, azureVMConfig.RoleName, userName, password, fingerprints...); err != nil {
 		return nil, err
 	}
 
-	configurationSets.ConfigurationSet = append(configurationSets.ConfigurationSet, networkConfig)
-
-	azureVMConfig.ConfigurationSets = configurationSets
+	if err := vmutils.ConfigureWithPublicSSH(azureVMConfig); err != nil {
+		return nil, err
+	}
 
 	if len(certPath) > 0 {
 		azureVMConfig.UseCertAuth = true
@@ -168,6 +272,41 @@ func AddAzureLinuxProvisioningConfig(azureVMConfig *Role, userName, password, ce
 	return azureVMConfig, nil
 }
 
+// AddAzureWindowsProvisioningConfig adds a WindowsProvisioningConfiguration
+// and a NetworkConfiguration (exposing RDP, and WinRM-over-HTTPS if winRM
+// holds an Https listener) to azureVMConfig. Listeners in winRM with any
+// other Protocol are ignored, and when winRM holds several Https listeners
+// only the last one's certificate thumbprint is kept. It is a thin wrapper around
+// vmutils.ConfigureForWindows, vmutils.ConfigureWithPublicRDP and
+// vmutils.ConfigureWithPublicWinRMHTTPS, kept so existing callers don't
+// have to move to vmutils themselves; new code should call vmutils
+// directly.
+func AddAzureWindowsProvisioningConfig(azureVMConfig *Role, computerName, adminUsername, adminPassword string, enableAutomaticUpdates bool, timeZone string, winRM *WinRMConfig) (*Role, error) {
+	fmt.Println("Adding azure provisioning configuration... ")
+
+	if err := vmutils.ConfigureForWindows(azureVMConfig, computerName, adminUsername, adminPassword, enableAutomaticUpdates, timeZone); err != nil {
+		return nil, err
+	}
+
+	if err := vmutils.ConfigureWithPublicRDP(azureVMConfig); err != nil {
+		return nil, err
+	}
+
+	if winRM != nil {
+		for _, listener := range winRM.Listeners {
+			if listener.Protocol != "Https" {
+				continue
+			}
+
+			if err := vmutils.ConfigureWithPublicWinRMHTTPS(azureVMConfig, listener.CertificateThumbprint); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return azureVMConfig, nil
+}
+
 func SetAzureVMExtension(azureVMConfiguration *Role, name string, publisher string, version string, referenceName string, state string, publicConfigurationValue string, privateConfigurationValue string) (*Role) {
 	fmt.Printf("Setting azure VM extension: %s... \n", name)
 
@@ -201,6 +340,10 @@ func SetAzureVMExtension(azureVMConfiguration *Role, name string, publisher stri
 	return azureVMConfiguration
 }
 
+// SetAzureDockerVMExtension opens dockerPort and attaches the Docker
+// resource extension to azureVMConfiguration. It is a thin wrapper around
+// vmutils.ConfigureWithExtension, kept so existing callers don't have to
+// move to vmutils themselves; new code should call vmutils directly.
 func SetAzureDockerVMExtension(azureVMConfiguration *Role, dockerCertDir string, dockerPort int, version string) (*Role, error) {
 	if len(version) == 0 {
 		version = "0.3"
@@ -217,10 +360,212 @@ func SetAzureDockerVMExtension(azureVMConfiguration *Role, dockerCertDir string,
 		return nil, err
 	}
 
-	azureVMConfiguration = SetAzureVMExtension(azureVMConfiguration, "DockerExtension", "MSOpenTech.Extensions", version, "DockerExtension", "enable", publicConfiguration, privateConfiguration)
+	if err := vmutils.ConfigureWithExtension(azureVMConfiguration, "DockerExtension", "MSOpenTech.Extensions", version, "DockerExtension", "enable", publicConfiguration, privateConfiguration); err != nil {
+		return nil, err
+	}
+
 	return azureVMConfiguration, nil
 }
 
+func AddDataDisk(role *Role, label string, lun int, sizeGB int, hostCaching string, mediaLink string) error {
+	if len(mediaLink) == 0 {
+		mediaLink = getDataDiskMediaLink(role.OSVirtualHardDisk.MediaLink, label)
+	}
+
+	dataDisk := DataVirtualHardDisk{}
+	dataDisk.DiskName = label
+	dataDisk.Lun = lun
+	dataDisk.LogicalDiskSizeInGB = sizeGB
+	dataDisk.HostCaching = hostCaching
+	dataDisk.MediaLink = mediaLink
+
+	role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk = append(role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk, dataDisk)
+	return nil
+}
+
+func AddExistingDataDisk(role *Role, mediaLink string, lun int, hostCaching string) error {
+	dataDisk := DataVirtualHardDisk{}
+	dataDisk.Lun = lun
+	dataDisk.HostCaching = hostCaching
+	dataDisk.MediaLink = mediaLink
+
+	role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk = append(role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk, dataDisk)
+	return nil
+}
+
+func ResizeOSDisk(role *Role, sizeGB int) error {
+	role.OSVirtualHardDisk.ResizedSizeInGB = sizeGB
+	return nil
+}
+
+// EndpointSpec describes an input endpoint to add to a role, beyond the
+// plain name/protocol/port mappings createEndpoint already supports: load
+// balanced sets, health probes, and idle timeouts.
+type EndpointSpec struct {
+	Name string
+	Protocol string
+	LocalPort int
+	Port int
+	LoadBalancedEndpointSetName string
+	Probe *LoadBalancerProbe
+	IdleTimeoutInMinutes int
+}
+
+func AddEndpoint(role *Role, ep EndpointSpec) error {
+	idx := findConfigurationSet(role.ConfigurationSets.ConfigurationSet, "NetworkConfiguration")
+	if idx == -1 {
+		return errors.New(networkConfDoesNotExistError)
+	}
+
+	endpoint := createEndpoint(ep.Name, ep.Protocol, ep.Port, ep.LocalPort)
+	endpoint.LoadBalancedEndpointSetName = ep.LoadBalancedEndpointSetName
+	endpoint.IdleTimeoutInMinutes = ep.IdleTimeoutInMinutes
+	endpoint.LoadBalancerProbe = ep.Probe
+
+	configurationSets := role.ConfigurationSets.ConfigurationSet
+	configurationSets[idx].InputEndpoints.InputEndpoint = append(configurationSets[idx].InputEndpoints.InputEndpoint, endpoint)
+	return nil
+}
+
+func SetEndpointACL(role *Role, endpointName string, rules []ACLRule) error {
+	networkIdx := findConfigurationSet(role.ConfigurationSets.ConfigurationSet, "NetworkConfiguration")
+	if networkIdx == -1 {
+		return errors.New(networkConfDoesNotExistError)
+	}
+
+	endpoints := role.ConfigurationSets.ConfigurationSet[networkIdx].InputEndpoints.InputEndpoint
+	for i := 0; i < len(endpoints); i++ {
+		if endpoints[i].Name != endpointName {
+			continue
+		}
+
+		endpoints[i].EndpointACL = &EndpointACL{Rules: ACLRules{Rule: rules}}
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf(endpointDoesNotExistError, endpointName))
+}
+
+func findConfigurationSet(configurationSets []ConfigurationSet, configurationSetType string) int {
+	for i := 0; i < len(configurationSets); i++ {
+		if configurationSets[i].ConfigurationSetType == configurationSetType {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func DeleteDataDisk(cloudserviceName, deploymentName, roleName string, lun int) error {
+	requestURL := fmt.Sprintf(azureDataDiskURL, cloudserviceName, deploymentName, roleName, lun)
+	requestId, err := azure.SendAzureDeleteRequest(requestURL)
+	if err != nil {
+		return err
+	}
+
+	azure.WaitAsyncOperation(requestId)
+	return nil
+}
+
+// StorageRef identifies the storage account (and, for write operations, the
+// blob container within it) an extension helper should use to stage files.
+type StorageRef struct {
+	AccountName string
+	AccountKey string
+	ContainerName string
+}
+
+// SetAzureCustomScriptExtension uploads scriptFiles to storage and attaches
+// a CustomScriptForLinux/CustomScriptExtension resource extension to role
+// (the OS is inferred from role's provisioning configuration) that runs
+// commandToExecute against them on first boot.
+func SetAzureCustomScriptExtension(role *Role, scriptFiles []string, commandToExecute string, storage StorageRef) (*Role, error) {
+	fileUris, err := uploadCustomScriptFiles(scriptFiles, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	publicConfig, err := createCustomScriptPublicConfig(fileUris, commandToExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	privateConfig, err := createCustomScriptPrivateConfig(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	publisher, extensionType := customScriptExtensionDetails(role)
+	role = SetAzureVMExtension(role, extensionType, publisher, customScriptVersion, extensionType, "enable", publicConfig, privateConfig)
+
+	return role, nil
+}
+
+func customScriptExtensionDetails(role *Role) (publisher, extensionType string) {
+	if findConfigurationSet(role.ConfigurationSets.ConfigurationSet, "WindowsProvisioningConfiguration") != -1 {
+		return customScriptWindowsPublisher, customScriptWindowsType
+	}
+
+	return customScriptLinuxPublisher, customScriptLinuxType
+}
+
+func uploadCustomScriptFiles(scriptFiles []string, storage StorageRef) ([]string, error) {
+	fileUris := make([]string, 0, len(scriptFiles))
+
+	for _, scriptFile := range scriptFiles {
+		data, err := ioutil.ReadFile(scriptFile)
+		if err != nil {
+			return nil, err
+		}
+
+		blobName := path.Base(scriptFile)
+		blobURL, err := storageServiceClient.UploadBlob(storage.AccountName, storage.AccountKey, storage.ContainerName, blobName, data)
+		if err != nil {
+			return nil, err
+		}
+
+		fileUris = append(fileUris, blobURL)
+	}
+
+	return fileUris, nil
+}
+
+// customScriptPublicConfig and customScriptPrivateConfig mirror the JSON
+// documents Azure's CustomScript extensions expect; marshaling them via
+// encoding/json (rather than string templates) keeps commandToExecute and
+// the storage credentials properly escaped.
+type customScriptPublicConfig struct {
+	FileUris []string `json:"fileUris"`
+	CommandToExecute string `json:"commandToExecute"`
+}
+
+type customScriptPrivateConfig struct {
+	StorageAccountName string `json:"storageAccountName"`
+	StorageAccountKey string `json:"storageAccountKey"`
+}
+
+func createCustomScriptPublicConfig(fileUris []string, commandToExecute string) (string, error) {
+	config := customScriptPublicConfig{FileUris: fileUris, CommandToExecute: commandToExecute}
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	return string(configBytes), nil
+}
+
+func createCustomScriptPrivateConfig(storage StorageRef) (string, error) {
+	config := customScriptPrivateConfig{StorageAccountName: storage.AccountName, StorageAccountKey: storage.AccountKey}
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	return string(configBytes), nil
+}
+
 func GetVMDeployment(cloudserviceName, deploymentName string) (*VMDeployment, error) {
 	deployment := new(VMDeployment)
 
@@ -268,68 +613,104 @@ func GetRole(cloudserviceName, deploymentName, roleName string) (*Role, error) {
 }
 
 func StartRole(cloudserviceName, deploymentName, roleName string) (error) {
+	return StartRoleWithContext(context.Background(), defaultPollInterval, cloudserviceName, deploymentName, roleName)
+}
+
+func StartRoleWithContext(ctx context.Context, pollInterval time.Duration, cloudserviceName, deploymentName, roleName string) error {
+	requestId, err := StartRoleAsync(cloudserviceName, deploymentName, roleName)
+	if err != nil {
+		return err
+	}
+
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
+}
+
+// StartRoleAsync submits the operation without waiting for it to finish,
+// returning the request ID so the caller can poll it themselves.
+func StartRoleAsync(cloudserviceName, deploymentName, roleName string) (string, error) {
 	startRoleOperation := createStartRoleOperation()
 
 	startRoleOperationBytes, err := xml.Marshal(startRoleOperation)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	requestURL := fmt.Sprintf(azureOperationsURL, cloudserviceName, deploymentName, roleName)
-	requestId, azureErr := azure.SendAzurePostRequest(requestURL, startRoleOperationBytes)
-	if azureErr != nil {
-		return azureErr
+	return azure.SendAzurePostRequest(requestURL, startRoleOperationBytes)
+}
+
+func ShutdownRole(cloudserviceName, deploymentName, roleName string) (error) {
+	return ShutdownRoleWithContext(context.Background(), defaultPollInterval, cloudserviceName, deploymentName, roleName)
+}
+
+func ShutdownRoleWithContext(ctx context.Context, pollInterval time.Duration, cloudserviceName, deploymentName, roleName string) error {
+	requestId, err := ShutdownRoleAsync(cloudserviceName, deploymentName, roleName)
+	if err != nil {
+		return err
 	}
 
-	azure.WaitAsyncOperation(requestId)
-	return nil
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
 }
 
-func ShutdownRole(cloudserviceName, deploymentName, roleName string) (error) {
+// ShutdownRoleAsync submits the operation without waiting for it to finish,
+// returning the request ID so the caller can poll it themselves.
+func ShutdownRoleAsync(cloudserviceName, deploymentName, roleName string) (string, error) {
 	shutdownRoleOperation := createShutdowRoleOperation()
 
 	shutdownRoleOperationBytes, err := xml.Marshal(shutdownRoleOperation)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	requestURL := fmt.Sprintf(azureOperationsURL, cloudserviceName, deploymentName, roleName)
-	requestId, azureErr := azure.SendAzurePostRequest(requestURL, shutdownRoleOperationBytes)
-	if azureErr != nil {
-		return azureErr
+	return azure.SendAzurePostRequest(requestURL, shutdownRoleOperationBytes)
+}
+
+func RestartRole(cloudserviceName, deploymentName, roleName string) (error) {
+	return RestartRoleWithContext(context.Background(), defaultPollInterval, cloudserviceName, deploymentName, roleName)
+}
+
+func RestartRoleWithContext(ctx context.Context, pollInterval time.Duration, cloudserviceName, deploymentName, roleName string) error {
+	requestId, err := RestartRoleAsync(cloudserviceName, deploymentName, roleName)
+	if err != nil {
+		return err
 	}
 
-	azure.WaitAsyncOperation(requestId)
-	return nil
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
 }
 
-func RestartRole(cloudserviceName, deploymentName, roleName string) (error) {
+// RestartRoleAsync submits the operation without waiting for it to finish,
+// returning the request ID so the caller can poll it themselves.
+func RestartRoleAsync(cloudserviceName, deploymentName, roleName string) (string, error) {
 	restartRoleOperation := createRestartRoleOperation()
 
 	restartRoleOperationBytes, err := xml.Marshal(restartRoleOperation)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	requestURL :=  fmt.Sprintf(azureOperationsURL, cloudserviceName, deploymentName, roleName)
-	requestId, azureErr := azure.SendAzurePostRequest(requestURL, restartRoleOperationBytes)
-	if azureErr != nil {
-		return azureErr
-	}
-
-	azure.WaitAsyncOperation(requestId)
-	return nil
+	return azure.SendAzurePostRequest(requestURL, restartRoleOperationBytes)
 }
 
 func DeleteRole(cloudserviceName, deploymentName, roleName string) (error) {
-	requestURL :=  fmt.Sprintf(azureRoleURL, cloudserviceName, deploymentName, roleName)
-	requestId, azureErr := azure.SendAzureDeleteRequest(requestURL)
-	if azureErr != nil {
-		return azureErr
+	return DeleteRoleWithContext(context.Background(), defaultPollInterval, cloudserviceName, deploymentName, roleName)
+}
+
+func DeleteRoleWithContext(ctx context.Context, pollInterval time.Duration, cloudserviceName, deploymentName, roleName string) error {
+	requestId, err := DeleteRoleAsync(cloudserviceName, deploymentName, roleName)
+	if err != nil {
+		return err
 	}
 
-	azure.WaitAsyncOperation(requestId)
-	return nil
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
+}
+
+// DeleteRoleAsync submits the delete without waiting for it to finish,
+// returning the request ID so the caller can poll it themselves.
+func DeleteRoleAsync(cloudserviceName, deploymentName, roleName string) (string, error) {
+	requestURL :=  fmt.Sprintf(azureRoleURL, cloudserviceName, deploymentName, roleName)
+	return azure.SendAzureDeleteRequest(requestURL)
 }
 
 // REGION PUBLIC METHODS ENDS
@@ -437,17 +818,47 @@ func createHostedServiceDeploymentConfig(dnsName, location string) (HostedServic
 	return deployment
 }
 
-func createVMDeploymentConfig(role *Role) (VMDeployment) {
+func createVMDeploymentConfig(role *Role, options DeploymentOptions) (VMDeployment) {
 	deployment := VMDeployment{}
 	deployment.Name = role.RoleName
 	deployment.Xmlns = azureXmlns
 	deployment.DeploymentSlot = "Production"
+	if len(options.DeploymentSlot) > 0 {
+		deployment.DeploymentSlot = options.DeploymentSlot
+	}
 	deployment.Label = role.RoleName
+	deployment.VirtualNetworkName = options.VirtualNetworkName
+	deployment.ReservedIPName = options.ReservedIPName
 	deployment.RoleList.Role = append(deployment.RoleList.Role, role)
 
 	return deployment
 }
 
+// applyNetworkConfigOptions copies the network-related DeploymentOptions
+// onto role's existing NetworkConfiguration set, if it has one. The set
+// itself is created earlier by AddAzureLinuxProvisioningConfig or
+// AddAzureWindowsProvisioningConfig, so there's nothing to do when options
+// is the zero value or the role hasn't been provisioned yet.
+func applyNetworkConfigOptions(role *Role, options DeploymentOptions) {
+	if len(options.SubnetNames) == 0 && len(options.StaticVirtualNetworkIPAddress) == 0 {
+		return
+	}
+
+	idx := findConfigurationSet(role.ConfigurationSets.ConfigurationSet, "NetworkConfiguration")
+	if idx == -1 {
+		return
+	}
+
+	networkConfig := &role.ConfigurationSets.ConfigurationSet[idx]
+	if len(options.SubnetNames) > 0 {
+		networkConfig.SubnetNames = options.SubnetNames
+	}
+
+	if len(options.StaticVirtualNetworkIPAddress) > 0 {
+		networkConfig.StaticVirtualNetworkIPAddress = options.StaticVirtualNetworkIPAddress
+	}
+}
+
 func createAzureVMRole(name, instanceSize, imageName, location string) (*Role, error){
 	config := new(Role)
 	config.RoleName = name
@@ -510,6 +921,14 @@ func getVHDMediaLink(dnsName, location string) (string, error){
 	return vhdMediaLink, nil
 }
 
+// getDataDiskMediaLink builds a media link for a new data disk in the same
+// storage account/container as an existing VHD (typically the role's OS
+// disk), so callers don't have to resolve a storage account a second time.
+func getDataDiskMediaLink(existingMediaLink, diskLabel string) string {
+	container := existingMediaLink[:strings.LastIndex(existingMediaLink, "/")+1]
+	return container + diskLabel + "-" + time.Now().Local().Format("20060102150405") + ".vhd"
+}
+
 // newUUID generates a random UUID according to RFC 4122
 func newUUID() (string, error) {
 	uuid := make([]byte, 16)
@@ -526,34 +945,7 @@ func newUUID() (string, error) {
 	return fmt.Sprintf("%x", uuid[10:]), nil
 }
 
-func createLinuxProvisioningConfig(dnsName, userName, userPassword, certPath string) (ConfigurationSet, error) {
-	provisioningConfig := ConfigurationSet{}
-
-	disableSshPasswordAuthentication := false
-	if len(userPassword) == 0 {
-		disableSshPasswordAuthentication = true
-		// We need to set dummy password otherwise azure API will throw an error
-		userPassword = "P@ssword1"
-	}
-
-	provisioningConfig.DisableSshPasswordAuthentication = disableSshPasswordAuthentication
-	provisioningConfig.ConfigurationSetType = "LinuxProvisioningConfiguration"
-	provisioningConfig.HostName = dnsName
-	provisioningConfig.UserName = userName
-	provisioningConfig.UserPassword = userPassword
-
-	if len(certPath) > 0 {
-		var err error
-		provisioningConfig.SSH, err = createSshConfig(certPath, userName)
-		if err != nil {
-			return provisioningConfig, err
-		}
-	}
-
-	return provisioningConfig, nil
-}
-
-func uploadServiceCert(dnsName, certPath string) (error) {
+func uploadServiceCertWithContext(ctx context.Context, pollInterval time.Duration, dnsName, certPath string) error {
 	certificateConfig, err := createServiceCertDeploymentConf(certPath)
 	if err != nil {
 		return err
@@ -570,8 +962,7 @@ func uploadServiceCert(dnsName, certPath string) (error) {
 		return azureErr
 	}
 
-	err = azure.WaitAsyncOperation(requestId)
-	return err
+	return azure.WaitAsyncOperationWithContext(ctx, requestId, pollInterval)
 }
 
 func createServiceCertDeploymentConf(certPath string) (ServiceCertificate, error) {
@@ -589,27 +980,6 @@ func createServiceCertDeploymentConf(certPath string) (ServiceCertificate, error
 	return certConfig, nil
 }
 
-func createSshConfig(certPath, userName string) (SSH, error) {
-	sshConfig := SSH{}
-	publicKey := PublicKey{}
-
-	err := checkServiceCertExtension(certPath)
-	if err != nil {
-		return sshConfig, err
-	}
-
-	fingerprint, err := getServiceCertFingerprint(certPath)
-	if err != nil {
-		return sshConfig, err
-	}
-
-	publicKey.Fingerprint = fingerprint
-	publicKey.Path = "/home/" + userName + "/.ssh/authorized_keys"
-
-	sshConfig.PublicKeys.PublicKey = append(sshConfig.PublicKeys.PublicKey, publicKey)
-	return sshConfig, nil
-}
-
 func getServiceCertFingerprint(certPath string) (string, error) {
 	certData, readErr := ioutil.ReadFile(certPath)
 	if readErr != nil {
@@ -638,24 +1008,6 @@ func checkServiceCertExtension(certPath string) (error) {
 	return nil
 }
 
-func createNetworkConfig(os string) (ConfigurationSet, error) {
-	networkConfig := ConfigurationSet{}
-	networkConfig.ConfigurationSetType = "NetworkConfiguration"
-
-	var endpoint InputEndpoint
-	if os == osLinux {
-		endpoint = createEndpoint("ssh", "tcp", 22, 22)
-	} else if os == osWindows {
-		//!TODO add rdp endpoint
-	} else {
-		return networkConfig, errors.New(fmt.Sprintf(invalidOSError))
-	}
-
-	networkConfig.InputEndpoints.InputEndpoint = append(networkConfig.InputEndpoints.InputEndpoint, endpoint)
-
-	return networkConfig, nil
-}
-
 func createEndpoint(name string, protocol string, extertalPort int, internalPort int) (InputEndpoint) {
 	endpoint := InputEndpoint{}
 	endpoint.Name = name
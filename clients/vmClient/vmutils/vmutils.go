@@ -0,0 +1,310 @@
+// Package vmutils provides small, composable helpers for building up an
+// entities.Role. Unlike the higher level functions in vmClient (which mix
+// role construction with the HTTP/XML plumbing needed to talk to the
+// management API), every function here except
+// ConfigureDeploymentFromRemoteImage (which looks up the image and a
+// storage account through the management API) only mutates the role: it
+// takes a *entities.Role, validates whatever it depends on is already in
+// place, and returns an error instead of panicking or silently doing
+// nothing. The endpoint helpers (ConfigureWithPublicSSH, ConfigureWithPublicRDP,
+// ConfigureWithPublicWinRMHTTPS) return an error unless ConfigureForLinux or
+// ConfigureForWindows has been called on the role first. vmClient builds its own legacy provisioning helpers on
+// top of this package, so this package depends only on entities, never on
+// vmClient, to avoid an import cycle.
+package vmutils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/imageClient"
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/locationClient"
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/storageServiceClient"
+	"github.com/MSOpenTech/azure-sdk-for-go/clients/vmClient/entities"
+)
+
+const (
+	linuxProvisioningConfigurationSet = "LinuxProvisioningConfiguration"
+	windowsProvisioningConfigurationSet = "WindowsProvisioningConfiguration"
+	networkConfigurationSet = "NetworkConfiguration"
+
+	sshPort = 22
+	rdpPort = 3389
+	winRMHttpsPort = 5986
+
+	roleIsNilError = "role cannot be nil"
+	provisioningConfigDoesNotExistError = "role has no %s provisioning configuration set; call ConfigureForLinux or ConfigureForWindows first"
+	networkConfigDoesNotExistError = "role has no network configuration set; call ConfigureForLinux or ConfigureForWindows first"
+)
+
+// ConfigureForLinux adds a LinuxProvisioningConfiguration to role, along with
+// an (initially empty) network configuration set that later calls such as
+// ConfigureWithPublicSSH can populate. Calling it more than once on the same
+// role updates the existing configuration in place.
+func ConfigureForLinux(role *entities.Role, hostname, user, password string, sshPubKeyFingerprint ...string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	provisioningConfig := provisioningConfigurationSet(role, linuxProvisioningConfigurationSet)
+	provisioningConfig.DisableSshPasswordAuthentication = len(password) == 0
+	provisioningConfig.HostName = hostname
+	provisioningConfig.UserName = user
+	provisioningConfig.UserPassword = password
+
+	provisioningConfig.SSH.PublicKeys.PublicKey = nil
+	for _, fingerprint := range sshPubKeyFingerprint {
+		publicKey := entities.PublicKey{}
+		publicKey.Fingerprint = fingerprint
+		publicKey.Path = "/home/" + user + "/.ssh/authorized_keys"
+		provisioningConfig.SSH.PublicKeys.PublicKey = append(provisioningConfig.SSH.PublicKeys.PublicKey, publicKey)
+	}
+
+	return ensureNetworkConfigurationSet(role)
+}
+
+// ConfigureForWindows adds a WindowsProvisioningConfiguration to role, along
+// with an (initially empty) network configuration set. WinRM listeners are
+// added separately via ConfigureWithPublicWinRMHTTPS.
+func ConfigureForWindows(role *entities.Role, computerName, adminUsername, adminPassword string, enableAutomaticUpdates bool, timeZone string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	provisioningConfig := provisioningConfigurationSet(role, windowsProvisioningConfigurationSet)
+	provisioningConfig.ComputerName = computerName
+	provisioningConfig.AdminUsername = adminUsername
+	provisioningConfig.AdminPassword = adminPassword
+	provisioningConfig.EnableAutomaticUpdates = enableAutomaticUpdates
+	provisioningConfig.TimeZone = timeZone
+
+	return ensureNetworkConfigurationSet(role)
+}
+
+// ConfigureWithPublicSSH exposes the standard SSH endpoint (22/22) on role.
+// The role must already have a network configuration set, typically added
+// by ConfigureForLinux.
+func ConfigureWithPublicSSH(role *entities.Role) error {
+	return addEndpointIfMissing(role, "ssh", "tcp", sshPort, sshPort)
+}
+
+// ConfigureWithPublicRDP exposes the standard RDP endpoint (3389/3389) on
+// role. The role must already have a network configuration set, typically
+// added by ConfigureForWindows.
+func ConfigureWithPublicRDP(role *entities.Role) error {
+	return addEndpointIfMissing(role, "rdp", "tcp", rdpPort, rdpPort)
+}
+
+// ConfigureWithPublicWinRMHTTPS exposes the standard WinRM-over-HTTPS
+// endpoint (5986/5986) on role and registers an HTTPS WinRM listener using
+// certificateThumbprint on the role's Windows provisioning configuration,
+// replacing any Https listener already there. The role must already have a
+// WindowsProvisioningConfiguration set and a network configuration set,
+// typically both added by ConfigureForWindows.
+func ConfigureWithPublicWinRMHTTPS(role *entities.Role, certificateThumbprint string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	idx := findConfigurationSet(role, windowsProvisioningConfigurationSet)
+	if idx == -1 {
+		return errors.New(fmt.Sprintf(provisioningConfigDoesNotExistError, "Windows"))
+	}
+
+	if findConfigurationSet(role, networkConfigurationSet) == -1 {
+		return errors.New(networkConfigDoesNotExistError)
+	}
+
+	provisioningConfig := &role.ConfigurationSets.ConfigurationSet[idx]
+	if provisioningConfig.WinRMListeners == nil {
+		provisioningConfig.WinRMListeners = &entities.WinRMListeners{}
+	}
+
+	listeners := provisioningConfig.WinRMListeners.Listener[:0]
+	for _, listener := range provisioningConfig.WinRMListeners.Listener {
+		if listener.Protocol != "Https" {
+			listeners = append(listeners, listener)
+		}
+	}
+
+	listener := entities.WinRMListener{}
+	listener.Protocol = "Https"
+	listener.CertificateThumbprint = certificateThumbprint
+	provisioningConfig.WinRMListeners.Listener = append(listeners, listener)
+
+	return addEndpointIfMissing(role, "winrm-https", "tcp", winRMHttpsPort, winRMHttpsPort)
+}
+
+// ConfigureWithNewDataDisk attaches a newly created data disk to role. The
+// VHD is created at destination, which is used as the disk's media link
+// as given; no media link is generated.
+func ConfigureWithNewDataDisk(role *entities.Role, label, destination string, sizeGB int, caching string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	dataDisk := entities.DataVirtualHardDisk{}
+	dataDisk.DiskName = label
+	dataDisk.Lun = len(role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk)
+	dataDisk.LogicalDiskSizeInGB = sizeGB
+	dataDisk.HostCaching = caching
+	dataDisk.MediaLink = destination
+
+	role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk = append(role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk, dataDisk)
+	return nil
+}
+
+// ConfigureWithExistingDataDisk attaches an already-provisioned VHD blob to
+// role as a data disk.
+func ConfigureWithExistingDataDisk(role *entities.Role, mediaLink string, caching string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	dataDisk := entities.DataVirtualHardDisk{}
+	dataDisk.Lun = len(role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk)
+	dataDisk.HostCaching = caching
+	dataDisk.MediaLink = mediaLink
+
+	role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk = append(role.OSVirtualHardDisk.DataVirtualHardDisks.DataVirtualHardDisk, dataDisk)
+	return nil
+}
+
+// ConfigureWithExtension attaches a resource extension to role.
+func ConfigureWithExtension(role *entities.Role, name, publisher, version, referenceName, state, publicConfigurationValue, privateConfigurationValue string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	extension := entities.ResourceExtensionReference{}
+	extension.Name = name
+	extension.Publisher = publisher
+	extension.Version = version
+	extension.ReferenceName = referenceName
+	extension.State = state
+
+	if len(privateConfigurationValue) > 0 {
+		param := entities.ResourceExtensionParameter{}
+		param.Key = "ignored"
+		param.Value = base64.StdEncoding.EncodeToString([]byte(privateConfigurationValue))
+		param.Type = "Private"
+		extension.ResourceExtensionParameterValues.ResourceExtensionParameterValue = append(extension.ResourceExtensionParameterValues.ResourceExtensionParameterValue, param)
+	}
+
+	if len(publicConfigurationValue) > 0 {
+		param := entities.ResourceExtensionParameter{}
+		param.Key = "ignored"
+		param.Value = base64.StdEncoding.EncodeToString([]byte(publicConfigurationValue))
+		param.Type = "Public"
+		extension.ResourceExtensionParameterValues.ResourceExtensionParameterValue = append(extension.ResourceExtensionParameterValues.ResourceExtensionParameterValue, param)
+	}
+
+	role.ResourceExtensionReferences.ResourceExtensionReference = append(role.ResourceExtensionReferences.ResourceExtensionReference, extension)
+	return nil
+}
+
+// ConfigureDeploymentFromRemoteImage points role's OS disk at imageName,
+// resolving it against the catalog of published images and generating a
+// media link in a storage account local to location.
+func ConfigureDeploymentFromRemoteImage(role *entities.Role, imageName, location string) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	if err := locationClient.ResolveLocation(location); err != nil {
+		return err
+	}
+
+	if err := imageClient.ResolveImageName(imageName); err != nil {
+		return err
+	}
+
+	mediaLink, err := vhdMediaLink(role.RoleName, location)
+	if err != nil {
+		return err
+	}
+
+	role.OSVirtualHardDisk.SourceImageName = imageName
+	role.OSVirtualHardDisk.MediaLink = mediaLink
+	return nil
+}
+
+func provisioningConfigurationSet(role *entities.Role, configurationSetType string) *entities.ConfigurationSet {
+	idx := findConfigurationSet(role, configurationSetType)
+	if idx == -1 {
+		configurationSet := entities.ConfigurationSet{}
+		configurationSet.ConfigurationSetType = configurationSetType
+		role.ConfigurationSets.ConfigurationSet = append(role.ConfigurationSets.ConfigurationSet, configurationSet)
+		idx = len(role.ConfigurationSets.ConfigurationSet) - 1
+	}
+
+	return &role.ConfigurationSets.ConfigurationSet[idx]
+}
+
+func ensureNetworkConfigurationSet(role *entities.Role) error {
+	if findConfigurationSet(role, networkConfigurationSet) != -1 {
+		return nil
+	}
+
+	networkConfig := entities.ConfigurationSet{}
+	networkConfig.ConfigurationSetType = networkConfigurationSet
+	role.ConfigurationSets.ConfigurationSet = append(role.ConfigurationSets.ConfigurationSet, networkConfig)
+	return nil
+}
+
+func addEndpointIfMissing(role *entities.Role, name, protocol string, port, localPort int) error {
+	if role == nil {
+		return errors.New(roleIsNilError)
+	}
+
+	idx := findConfigurationSet(role, networkConfigurationSet)
+	if idx == -1 {
+		return errors.New(networkConfigDoesNotExistError)
+	}
+
+	networkConfig := &role.ConfigurationSets.ConfigurationSet[idx]
+	for _, endpoint := range networkConfig.InputEndpoints.InputEndpoint {
+		if endpoint.Name == name {
+			return nil
+		}
+	}
+
+	endpoint := entities.InputEndpoint{}
+	endpoint.Name = name
+	endpoint.Protocol = protocol
+	endpoint.Port = port
+	endpoint.LocalPort = localPort
+
+	networkConfig.InputEndpoints.InputEndpoint = append(networkConfig.InputEndpoints.InputEndpoint, endpoint)
+	return nil
+}
+
+func findConfigurationSet(role *entities.Role, configurationSetType string) int {
+	for i := range role.ConfigurationSets.ConfigurationSet {
+		if role.ConfigurationSets.ConfigurationSet[i].ConfigurationSetType == configurationSetType {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func vhdMediaLink(dnsName, location string) (string, error) {
+	storageService, err := storageServiceClient.GetStorageServiceByLocation(location)
+	if err != nil {
+		return "", err
+	}
+
+	if storageService == nil {
+		return "", errors.New("no storage account available in " + location + " to host the OS disk; create one first")
+	}
+
+	blobEndpoint, err := storageServiceClient.GetBlobEndpoint(storageService)
+	if err != nil {
+		return "", err
+	}
+
+	return blobEndpoint + "vhds/" + dnsName + "-" + time.Now().Local().Format("20060102150405") + ".vhd", nil
+}